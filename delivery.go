@@ -0,0 +1,161 @@
+package graphqlws
+
+import "errors"
+
+// SubscriptionDeliveryPolicy controls what a subscription does when its
+// outbound delivery buffer is full because the client is consuming slower
+// than the publisher is producing.
+type SubscriptionDeliveryPolicy int
+
+const (
+	// PolicyBlock makes the publisher wait until the client has drained
+	// enough of the buffer to accept the new payload. This preserves
+	// every payload but means a single slow client stalls the publisher,
+	// and with it delivery to every subscription that the same Publish
+	// call has not reached yet.
+	PolicyBlock SubscriptionDeliveryPolicy = iota
+
+	// PolicyDropOldest discards the oldest buffered payload to make room
+	// for the new one, favoring freshness over completeness.
+	PolicyDropOldest
+
+	// PolicyDisconnect cancels the subscription the moment its buffer
+	// overflows, invoking OnCancelled and removing it from the manager.
+	PolicyDisconnect
+)
+
+// DefaultDeliveryBufferSize is the outbound buffer capacity used for a
+// subscription that doesn't set BufferSize explicitly.
+const DefaultDeliveryBufferSize = 16
+
+// ErrSlowConsumer is the error passed to OnCancelled and returned from
+// Err() when a subscription is disconnected under PolicyDisconnect because
+// it didn't drain its buffer in time.
+var ErrSlowConsumer = errors.New("graphqlws: subscription disconnected: slow consumer")
+
+// initDelivery sets up the subscription's outbound buffer and cancellation
+// plumbing. onDisconnect is called when PolicyDisconnect forces the
+// subscription to be torn down.
+func (s *Subscription) initDelivery(onDisconnect func(*Subscription, error)) {
+	if s.BufferSize <= 0 {
+		s.BufferSize = DefaultDeliveryBufferSize
+	}
+
+	s.out = make(chan *DataMessagePayload, s.BufferSize)
+	s.cancelled = make(chan struct{})
+	s.forceDisconnect = func(err error) {
+		s.cancelledOnce.Do(func() {
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+			close(s.cancelled)
+
+			if s.OnCancelled != nil {
+				s.OnCancelled(err)
+			}
+			if onDisconnect != nil {
+				onDisconnect(s, err)
+			}
+		})
+	}
+}
+
+// stopDelivery closes the subscription's cancelled channel if it hasn't
+// been already, so its runDelivery worker exits. Unlike forceDisconnect,
+// it doesn't set Err or invoke OnCancelled: it's what a normal, caller- or
+// server-initiated RemoveSubscription uses to tear down delivery, as
+// opposed to a DeliveryPolicy-forced disconnect.
+func (s *Subscription) stopDelivery() {
+	s.cancelledOnce.Do(func() {
+		close(s.cancelled)
+	})
+}
+
+// Cancelled returns a channel that's closed once the subscription has been
+// force-disconnected by its delivery policy or removed from the manager.
+// Err tells the two apart.
+func (s *Subscription) Cancelled() <-chan struct{} {
+	return s.cancelled
+}
+
+// Err returns the error that caused the subscription to be cancelled, or
+// nil if it hasn't been (or wasn't force-disconnected).
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// deliver enqueues payload for the subscription's delivery worker,
+// applying the subscription's DeliveryPolicy if the outbound buffer is
+// full. The checkpoint is only stamped once the payload is actually
+// accepted into the buffer: a payload dropped by PolicyDropOldest, or
+// never enqueued because PolicyDisconnect tore the subscription down,
+// must not advance the checkpoint past data the client was never sent.
+func (s *Subscription) deliver(payload *DataMessagePayload) {
+	select {
+	case <-s.cancelled:
+		return
+	default:
+	}
+
+	if s.enqueue(payload) {
+		s.stampCheckpoint(payload)
+	}
+}
+
+// enqueue applies the subscription's DeliveryPolicy to get payload onto
+// the outbound buffer, reporting whether it ended up there.
+func (s *Subscription) enqueue(payload *DataMessagePayload) bool {
+	select {
+	case s.out <- payload:
+		return true
+	default:
+	}
+
+	switch s.DeliveryPolicy {
+	case PolicyDropOldest:
+		select {
+		case <-s.out:
+		default:
+		}
+		select {
+		case s.out <- payload:
+			return true
+		default:
+			return false
+		}
+	case PolicyDisconnect:
+		if s.forceDisconnect != nil {
+			s.forceDisconnect(ErrSlowConsumer)
+		}
+		return false
+	default: // PolicyBlock
+		select {
+		case s.out <- payload:
+			return true
+		case <-s.cancelled:
+			return false
+		}
+	}
+}
+
+func (s *Subscription) stampCheckpoint(payload *DataMessagePayload) {
+	if s.CheckpointFunc != nil && s.onCheckpoint != nil {
+		s.onCheckpoint(s.CheckpointFunc(payload), payload)
+	}
+}
+
+// runDelivery drains the subscription's outbound buffer into its SendData
+// callback until the subscription is cancelled. It's started once, in its
+// own goroutine, when the subscription is added to the manager.
+func (s *Subscription) runDelivery() {
+	for {
+		select {
+		case payload := <-s.out:
+			s.SendData(payload)
+		case <-s.cancelled:
+			return
+		}
+	}
+}
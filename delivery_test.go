@@ -0,0 +1,106 @@
+package graphqlws
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeliverPolicyBlockBuffersInOrder(t *testing.T) {
+	s := &Subscription{DeliveryPolicy: PolicyBlock, BufferSize: 2}
+	s.initDelivery(nil)
+
+	s.deliver(&DataMessagePayload{Data: 1})
+	s.deliver(&DataMessagePayload{Data: 2})
+
+	select {
+	case p := <-s.out:
+		if p.Data != 1 {
+			t.Fatalf("got Data=%v, want 1", p.Data)
+		}
+	default:
+		t.Fatal("expected the first payload to be buffered")
+	}
+}
+
+func TestDeliverPolicyDropOldestDropsOldest(t *testing.T) {
+	s := &Subscription{DeliveryPolicy: PolicyDropOldest, BufferSize: 1}
+	s.initDelivery(nil)
+
+	s.deliver(&DataMessagePayload{Data: 1})
+	s.deliver(&DataMessagePayload{Data: 2}) // buffer is full: should evict 1, not 2
+
+	select {
+	case p := <-s.out:
+		if p.Data != 2 {
+			t.Fatalf("got Data=%v, want 2 (the oldest payload should have been dropped)", p.Data)
+		}
+	default:
+		t.Fatal("expected the newest payload to be buffered")
+	}
+}
+
+func TestDeliverPolicyDisconnectForcesDisconnectOnOverflow(t *testing.T) {
+	var disconnected *Subscription
+	var disconnectErr error
+
+	s := &Subscription{DeliveryPolicy: PolicyDisconnect, BufferSize: 1}
+	s.initDelivery(func(sub *Subscription, err error) {
+		disconnected = sub
+		disconnectErr = err
+	})
+
+	s.deliver(&DataMessagePayload{Data: 1})
+	s.deliver(&DataMessagePayload{Data: 2}) // buffer is full: should force-disconnect
+
+	select {
+	case <-s.Cancelled():
+	case <-time.After(time.Second):
+		t.Fatal("expected the subscription to be cancelled")
+	}
+
+	if disconnected != s {
+		t.Errorf("onDisconnect called with %v, want the subscription itself", disconnected)
+	}
+	if !errors.Is(disconnectErr, ErrSlowConsumer) {
+		t.Errorf("onDisconnect err = %v, want ErrSlowConsumer", disconnectErr)
+	}
+	if !errors.Is(s.Err(), ErrSlowConsumer) {
+		t.Errorf("Err() = %v, want ErrSlowConsumer", s.Err())
+	}
+}
+
+func TestDeliverDoesNotStampCheckpointForADroppedPayload(t *testing.T) {
+	var stamped []string
+
+	s := &Subscription{
+		DeliveryPolicy: PolicyDisconnect,
+		BufferSize:     1,
+		CheckpointFunc: func(p *DataMessagePayload) string { return p.Data.(string) },
+	}
+	s.onCheckpoint = func(checkpoint string, payload *DataMessagePayload) {
+		stamped = append(stamped, checkpoint)
+	}
+	s.initDelivery(nil)
+
+	s.deliver(&DataMessagePayload{Data: "a"})
+	s.deliver(&DataMessagePayload{Data: "b"}) // overflow: forces disconnect, "b" is never delivered
+
+	if len(stamped) != 1 || stamped[0] != "a" {
+		t.Errorf("stamped checkpoints = %v, want [\"a\"]", stamped)
+	}
+}
+
+func TestStopDeliveryIsIdempotent(t *testing.T) {
+	s := &Subscription{BufferSize: 1}
+	s.initDelivery(nil)
+
+	s.stopDelivery()
+	s.stopDelivery() // must not panic closing an already-closed channel
+
+	select {
+	case <-s.Cancelled():
+	default:
+		t.Fatal("expected the cancelled channel to be closed")
+	}
+}
@@ -0,0 +1,27 @@
+package graphqlws
+
+import "errors"
+
+// Sentinel errors returned by SubscriptionManager. Callers should check
+// against these with errors.Is instead of matching on error strings.
+var (
+	// ErrInvalidSubscription is returned by AddSubscription when the
+	// subscription fails basic validation (missing ID, query, connection
+	// or SendData callback) before it's parsed against the schema.
+	ErrInvalidSubscription = errors.New("graphqlws: invalid subscription")
+
+	// ErrAlreadySubscribed is returned by AddSubscription when a
+	// subscription with the same ID is already registered for the
+	// connection.
+	ErrAlreadySubscribed = errors.New("graphqlws: subscription already registered")
+
+	// ErrSubscriptionNotFound is returned by RemoveSubscription when the
+	// given subscription isn't (or is no longer) registered for its
+	// connection.
+	ErrSubscriptionNotFound = errors.New("graphqlws: subscription not found")
+
+	// ErrConnectionUnknown is returned by RemoveSubscription when the
+	// manager has no subscriptions registered for the connection at all
+	// and the subscription was never registered on it.
+	ErrConnectionUnknown = errors.New("graphqlws: connection unknown")
+)
@@ -0,0 +1,153 @@
+package graphqlws
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// subIndex maintains an incrementally updated field index over the active
+// subscriptions, so Publish only has to evaluate argument filters against
+// the subscriptions registered for the published field instead of
+// scanning every connection's subscriptions for every published event.
+type subIndex struct {
+	mu      sync.Mutex
+	byField map[string][]*Subscription
+}
+
+func newSubIndex() *subIndex {
+	return &subIndex{byField: make(map[string][]*Subscription)}
+}
+
+// add inserts the subscription into the bucket for each of its fields.
+func (idx *subIndex) add(subscription *Subscription) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, field := range subscription.Fields {
+		idx.byField[field] = append(idx.byField[field], subscription)
+	}
+}
+
+// remove takes the subscription out of the bucket for each of its fields.
+func (idx *subIndex) remove(subscription *Subscription) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, field := range subscription.Fields {
+		subs := idx.byField[field]
+		for i, sub := range subs {
+			if sub == subscription {
+				idx.byField[field] = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+		if len(idx.byField[field]) == 0 {
+			delete(idx.byField, field)
+		}
+	}
+}
+
+// match returns the subscriptions registered for field whose argument
+// filter is satisfied by args: every key the subscription filters on for
+// field must be present in args with an equal value. A subscription with
+// no filter for field matches any event for it. Unlike an exact signature
+// match, this tolerates args carrying extra keys the subscription doesn't
+// filter on (e.g. publishing the whole event as args).
+func (idx *subIndex) match(field string, args map[string]interface{}) []*Subscription {
+	idx.mu.Lock()
+	candidates := idx.byField[field]
+	out := make([]*Subscription, len(candidates))
+	copy(out, candidates)
+	idx.mu.Unlock()
+
+	matches := out[:0]
+	for _, subscription := range out {
+		if filterSatisfiedBy(subscription.FieldArgs[field], args) {
+			matches = append(matches, subscription)
+		}
+	}
+	return matches
+}
+
+// filterSatisfiedBy reports whether every key/value pair in filter is
+// present (with an equal value) in args. An empty filter is satisfied by
+// anything.
+func filterSatisfiedBy(filter, args map[string]interface{}) bool {
+	for key, want := range filter {
+		got, ok := args[key]
+		if !ok || fmt.Sprintf("%v", got) != fmt.Sprintf("%v", want) {
+			return false
+		}
+	}
+	return true
+}
+
+// subscriptionFieldArgsFromDocument extracts the literal argument values of
+// each root selection in the document's operation, keyed by field name.
+// Arguments given as variables are resolved against the subscription's
+// variables so e.g. `commentAdded(postId: $id)` can be indexed on the
+// concrete value of $id.
+func subscriptionFieldArgsFromDocument(
+	document *ast.Document,
+	variables map[string]interface{},
+) map[string]map[string]interface{} {
+	fieldArgs := map[string]map[string]interface{}{}
+
+	for _, definition := range document.Definitions {
+		op, ok := definition.(*ast.OperationDefinition)
+		if !ok || op.SelectionSet == nil {
+			continue
+		}
+
+		for _, selection := range op.SelectionSet.Selections {
+			field, ok := selection.(*ast.Field)
+			if !ok || len(field.Arguments) == 0 {
+				continue
+			}
+
+			args := make(map[string]interface{}, len(field.Arguments))
+			for _, arg := range field.Arguments {
+				value, ok := literalArgumentValue(arg.Value, variables)
+				if ok {
+					args[arg.Name.Value] = value
+				}
+			}
+			fieldArgs[field.Name.Value] = args
+		}
+	}
+
+	return fieldArgs
+}
+
+// literalArgumentValue resolves an argument's AST value to a concrete Go
+// value, reading from variables when the value is a variable reference. It
+// reports false for values that can't be resolved to a literal (e.g. a
+// variable that wasn't supplied).
+func literalArgumentValue(value ast.Value, variables map[string]interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case *ast.StringValue:
+		return v.Value, true
+	case *ast.IntValue:
+		if n, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+			return n, true
+		}
+		return v.Value, true
+	case *ast.FloatValue:
+		if f, err := strconv.ParseFloat(v.Value, 64); err == nil {
+			return f, true
+		}
+		return v.Value, true
+	case *ast.BooleanValue:
+		return v.Value, true
+	case *ast.EnumValue:
+		return v.Value, true
+	case *ast.Variable:
+		value, ok := variables[v.Name.Value]
+		return value, ok
+	default:
+		return nil, false
+	}
+}
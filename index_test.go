@@ -0,0 +1,89 @@
+package graphqlws
+
+import (
+	"fmt"
+	"testing"
+)
+
+// benchField is the field the benchmarks below publish to; it's always
+// the last of the n fields makeIndexedSubscriptions spreads subscriptions
+// across, so only one of them is actually a candidate.
+func benchField(n int) string {
+	return fmt.Sprintf("field-%d", n-1)
+}
+
+// makeIndexedSubscriptions builds n subscriptions, each on its own
+// distinct field (so index.match only has a single candidate to consider
+// while linearMatch still has to scan all n), filtering on a distinct
+// value of "roomId". They're constructed directly rather than through
+// AddSubscription so the benchmarks measure index.match/linearMatch in
+// isolation.
+func makeIndexedSubscriptions(n int) []*Subscription {
+	subs := make([]*Subscription, n)
+	for i := range subs {
+		field := fmt.Sprintf("field-%d", i)
+		subs[i] = &Subscription{
+			ID:     fmt.Sprintf("sub-%d", i),
+			Fields: []string{field},
+			FieldArgs: map[string]map[string]interface{}{
+				field: {"roomId": fmt.Sprintf("room-%d", i)},
+			},
+		}
+	}
+	return subs
+}
+
+// linearMatch is the naive approach index.match replaced: scan every
+// subscription and test its filter, regardless of field. It's the
+// baseline the benchmarks below compare index.match against.
+func linearMatch(subs []*Subscription, field string, args map[string]interface{}) []*Subscription {
+	var matches []*Subscription
+	for _, sub := range subs {
+		hasField := false
+		for _, name := range sub.Fields {
+			if name == field {
+				hasField = true
+				break
+			}
+		}
+		if !hasField {
+			continue
+		}
+		if filterSatisfiedBy(sub.FieldArgs[field], args) {
+			matches = append(matches, sub)
+		}
+	}
+	return matches
+}
+
+func benchmarkIndexMatch(b *testing.B, n int) {
+	subs := makeIndexedSubscriptions(n)
+	idx := newSubIndex()
+	for _, sub := range subs {
+		idx.add(sub)
+	}
+	args := map[string]interface{}{"roomId": fmt.Sprintf("room-%d", n-1)}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.match(benchField(n), args)
+	}
+}
+
+func benchmarkLinearMatch(b *testing.B, n int) {
+	subs := makeIndexedSubscriptions(n)
+	args := map[string]interface{}{"roomId": fmt.Sprintf("room-%d", n-1)}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		linearMatch(subs, benchField(n), args)
+	}
+}
+
+func BenchmarkIndexMatch10Clients(b *testing.B)   { benchmarkIndexMatch(b, 10) }
+func BenchmarkIndexMatch100Clients(b *testing.B)  { benchmarkIndexMatch(b, 100) }
+func BenchmarkIndexMatch1000Clients(b *testing.B) { benchmarkIndexMatch(b, 1000) }
+
+func BenchmarkLinearMatch10Clients(b *testing.B)   { benchmarkLinearMatch(b, 10) }
+func BenchmarkLinearMatch100Clients(b *testing.B)  { benchmarkLinearMatch(b, 100) }
+func BenchmarkLinearMatch1000Clients(b *testing.B) { benchmarkLinearMatch(b, 1000) }
@@ -0,0 +1,260 @@
+package graphqlws
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+)
+
+// CheckpointFunc computes a checkpoint string for a delivered payload. See
+// Subscription.CheckpointFunc.
+type CheckpointFunc func(*DataMessagePayload) string
+
+// PersistentSubscriptionStore persists named subscriptions so they survive
+// client reconnects: a client opens a connection and starts a subscription
+// by name instead of sending an inline query, and the manager resumes it
+// from the stored checkpoint. Implementations can back this with Redis,
+// Postgres, or anything else that can store a (name, query, variables,
+// checkpoint) tuple.
+type PersistentSubscriptionStore interface {
+	// Save persists the query and variables for the named subscription,
+	// creating it if it doesn't exist yet.
+	Save(name string, query string, variables map[string]interface{}) error
+
+	// Load returns the stored query, variables and last acknowledged
+	// checkpoint for name. It reports false if name hasn't been saved.
+	Load(name string) (query string, variables map[string]interface{}, checkpoint string, ok bool)
+
+	// SetCheckpoint updates the last acknowledged checkpoint for name.
+	// It returns ErrSubscriptionNotFound if name hasn't been saved.
+	SetCheckpoint(name string, checkpoint string) error
+
+	// Append records payload as having been delivered at checkpoint for
+	// the named subscription, so a later Replay can resend it to a client
+	// that reconnects before acknowledging it.
+	Append(name string, checkpoint string, payload *DataMessagePayload) error
+
+	// Replay returns every payload appended for name after checkpoint, in
+	// delivery order, so a reconnecting client doesn't miss data
+	// published while it was disconnected. If checkpoint is empty or
+	// isn't found in the log, Replay returns everything recorded for
+	// name, favoring redelivering data the client already has over
+	// silently dropping data it doesn't.
+	Replay(name string, checkpoint string) ([]*DataMessagePayload, error)
+}
+
+// persistentSubscriptionManager wraps the default subscriptionManager with
+// a PersistentSubscriptionStore, so subscriptions registered by name can be
+// resumed across reconnects and acknowledged deliveries are written
+// through to the store.
+type persistentSubscriptionManager struct {
+	*subscriptionManager
+	store PersistentSubscriptionStore
+}
+
+// NewPersistentSubscriptionManager creates a subscription manager that
+// resolves subscriptions started with Subscription.Name against store,
+// resuming an existing query/variables/checkpoint instead of requiring the
+// client to resend them.
+func NewPersistentSubscriptionManager(schema *graphql.Schema, store PersistentSubscriptionStore) SubscriptionManager {
+	base := newSubscriptionManager(schema, NewLogger("subscriptions")).(*subscriptionManager)
+	return &persistentSubscriptionManager{subscriptionManager: base, store: store}
+}
+
+// AddSubscription resolves a named subscription against the store before
+// delegating to the default implementation: a subscription with a Name but
+// no Query is resumed from the last saved query, variables and checkpoint;
+// a subscription with both a Name and a Query is (re-)saved so it can be
+// resumed later.
+func (m *persistentSubscriptionManager) AddSubscription(conn Connection, subscription *Subscription) []error {
+	resuming := false
+
+	if subscription.Name != "" {
+		if subscription.Query == "" {
+			query, variables, checkpoint, ok := m.store.Load(subscription.Name)
+			if !ok {
+				return []error{fmt.Errorf("%w: persisted subscription %q", ErrSubscriptionNotFound, subscription.Name)}
+			}
+			subscription.Query = query
+			subscription.Variables = variables
+			subscription.Checkpoint = checkpoint
+			resuming = true
+		} else if err := m.store.Save(subscription.Name, subscription.Query, subscription.Variables); err != nil {
+			return []error{err}
+		}
+
+		subscription.onCheckpoint = func(checkpoint string, payload *DataMessagePayload) {
+			subscription.Checkpoint = checkpoint
+			if err := m.store.Append(subscription.Name, checkpoint, payload); err != nil {
+				m.logger.WithField("err", err).Warn("Failed to append delivered payload to subscription replay log")
+			}
+			if err := m.store.SetCheckpoint(subscription.Name, checkpoint); err != nil {
+				m.logger.WithField("err", err).Warn("Failed to persist subscription checkpoint")
+			}
+		}
+	}
+
+	if errs := m.subscriptionManager.registerSubscription(conn, subscription); len(errs) > 0 {
+		return errs
+	}
+
+	// A client resuming a persisted subscription may have missed events
+	// published while it was disconnected: replay everything recorded
+	// after its last acknowledged checkpoint before the subscription is
+	// added to the index, i.e. before it becomes visible to Publish at
+	// all. Otherwise a concurrent Publish could deliver a live event
+	// ahead of this backlog, reordering what's meant to be an ordered,
+	// at-least-once stream.
+	if resuming {
+		replayed, err := m.store.Replay(subscription.Name, subscription.Checkpoint)
+		if err != nil {
+			m.logger.WithField("err", err).Warn("Failed to replay persisted subscription")
+		}
+		for _, payload := range replayed {
+			subscription.deliver(payload)
+		}
+	}
+
+	m.index.add(subscription)
+
+	return nil
+}
+
+// Ack records checkpoint on the subscription and, when it's a persisted
+// subscription, writes it through to the store.
+func (m *persistentSubscriptionManager) Ack(conn Connection, subID string, checkpoint string) error {
+	subsInterface, ok := m.subscriptions.Load(conn)
+	if !ok {
+		return ErrConnectionUnknown
+	}
+	subs := subsInterface.(*sync.Map)
+
+	stored, ok := subs.Load(subID)
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+
+	subscription := stored.(*Subscription)
+	subscription.Checkpoint = checkpoint
+
+	if subscription.Name == "" {
+		return nil
+	}
+	return m.store.SetCheckpoint(subscription.Name, checkpoint)
+}
+
+// inMemoryPersistentSubscriptionStore is a PersistentSubscriptionStore
+// backed by a plain map. It's useful for tests and single-process
+// deployments; production deployments that need subscriptions to survive a
+// process restart should back PersistentSubscriptionStore with Redis,
+// Postgres, or similar.
+type inMemoryPersistentSubscriptionStore struct {
+	mu      sync.Mutex
+	records map[string]*persistedSubscription
+}
+
+type persistedSubscription struct {
+	query      string
+	variables  map[string]interface{}
+	checkpoint string
+	log        []replayEntry
+}
+
+// replayEntry is one delivered-payload record in a persisted subscription's
+// replay log.
+type replayEntry struct {
+	checkpoint string
+	payload    *DataMessagePayload
+}
+
+// NewInMemoryPersistentSubscriptionStore creates a PersistentSubscriptionStore
+// that keeps all records in memory.
+func NewInMemoryPersistentSubscriptionStore() PersistentSubscriptionStore {
+	return &inMemoryPersistentSubscriptionStore{records: map[string]*persistedSubscription{}}
+}
+
+func (s *inMemoryPersistentSubscriptionStore) Save(name string, query string, variables map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[name]
+	if !ok {
+		record = &persistedSubscription{}
+		s.records[name] = record
+	}
+	record.query = query
+	record.variables = variables
+	return nil
+}
+
+func (s *inMemoryPersistentSubscriptionStore) Load(name string) (string, map[string]interface{}, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[name]
+	if !ok {
+		return "", nil, "", false
+	}
+	return record.query, record.variables, record.checkpoint, true
+}
+
+func (s *inMemoryPersistentSubscriptionStore) SetCheckpoint(name string, checkpoint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[name]
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	record.checkpoint = checkpoint
+	return nil
+}
+
+func (s *inMemoryPersistentSubscriptionStore) Append(name string, checkpoint string, payload *DataMessagePayload) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[name]
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	record.log = append(record.log, replayEntry{checkpoint: checkpoint, payload: payload})
+	return nil
+}
+
+func (s *inMemoryPersistentSubscriptionStore) Replay(name string, checkpoint string) ([]*DataMessagePayload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[name]
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	if checkpoint == "" {
+		return payloadsFrom(record.log), nil
+	}
+
+	for i, entry := range record.log {
+		if entry.checkpoint == checkpoint {
+			return payloadsFrom(record.log[i+1:]), nil
+		}
+	}
+
+	// checkpoint isn't in the log (e.g. it predates the store's retention):
+	// replay everything rather than silently skip data the client might
+	// not have seen.
+	return payloadsFrom(record.log), nil
+}
+
+func payloadsFrom(entries []replayEntry) []*DataMessagePayload {
+	if len(entries) == 0 {
+		return nil
+	}
+	out := make([]*DataMessagePayload, len(entries))
+	for i, entry := range entries {
+		out[i] = entry.payload
+	}
+	return out
+}
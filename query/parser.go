@@ -0,0 +1,231 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokBool
+	tokEq
+	tokNeq
+	tokAnd
+	tokOr
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	b    bool
+}
+
+// tokenize turns source into a token stream. It's a small hand-rolled
+// lexer; there's no need for anything fancier given the size of the
+// language.
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+
+	runes := []rune(source)
+	i := 0
+	for i < len(runes) {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "("})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")"})
+			i++
+
+		case r == '=':
+			tokens = append(tokens, token{kind: tokEq, text: "="})
+			i++
+
+		case r == '!':
+			if i+1 >= len(runes) || runes[i+1] != '=' {
+				return nil, fmt.Errorf("query: expected '!=' at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokNeq, text: "!="})
+			i += 2
+
+		case r == '\'':
+			start := i + 1
+			j := start
+			for j < len(runes) && runes[j] != '\'' {
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("query: unterminated string starting at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokString, text: string(runes[start:j])})
+			i = j + 1
+
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			text := string(runes[start:i])
+			num, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("query: invalid number %q", text)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: text, num: num})
+
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			text := string(runes[start:i])
+
+			switch strings.ToUpper(text) {
+			case "AND":
+				tokens = append(tokens, token{kind: tokAnd, text: text})
+			case "OR":
+				tokens = append(tokens, token{kind: tokOr, text: text})
+			case "TRUE":
+				tokens = append(tokens, token{kind: tokBool, text: text, b: true})
+			case "FALSE":
+				tokens = append(tokens, token{kind: tokBool, text: text, b: false})
+			default:
+				tokens = append(tokens, token{kind: tokIdent, text: text})
+			}
+
+		default:
+			return nil, fmt.Errorf("query: unexpected character %q at position %d", r, i)
+		}
+	}
+
+	return tokens, nil
+}
+
+// parser is a recursive-descent parser over the token stream produced by
+// tokenize. Grammar, loosest to tightest binding:
+//
+//	orExpr     := andExpr ("OR" andExpr)*
+//	andExpr    := unary ("AND" unary)*
+//	unary      := "(" orExpr ")" | comparison
+//	comparison := identifier ("=" | "!=") literal
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return token{kind: tokEOF}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) parseOr() (Query, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Query, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Query, error) {
+	if p.peek().kind == tokLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokRParen {
+			return nil, fmt.Errorf("query: expected ')', got %q", p.peek().text)
+		}
+		p.next()
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Query, error) {
+	identTok := p.peek()
+	if identTok.kind != tokIdent {
+		return nil, fmt.Errorf("query: expected identifier, got %q", identTok.text)
+	}
+	if identTok.text != "field" && !strings.HasPrefix(identTok.text, "args.") && !strings.HasPrefix(identTok.text, "payload.") {
+		return nil, fmt.Errorf("query: unknown identifier %q (want field, args.<name> or payload.<name>)", identTok.text)
+	}
+	p.next()
+
+	opTok := p.next()
+	var negate bool
+	switch opTok.kind {
+	case tokEq:
+		negate = false
+	case tokNeq:
+		negate = true
+	default:
+		return nil, fmt.Errorf("query: expected '=' or '!=' after %q, got %q", identTok.text, opTok.text)
+	}
+
+	valTok := p.next()
+	var value interface{}
+	switch valTok.kind {
+	case tokString:
+		value = valTok.text
+	case tokNumber:
+		value = valTok.num
+	case tokBool:
+		value = valTok.b
+	default:
+		return nil, fmt.Errorf("query: expected a literal value, got %q", valTok.text)
+	}
+
+	return &compareExpr{identifier: identTok.text, negate: negate, value: value}, nil
+}
@@ -0,0 +1,198 @@
+// Package query implements a small boolean expression language for
+// filtering subscription events on their field name, arguments and
+// payload, modelled on tendermint's pubsub query subpackage.
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Event is the unit of data a compiled Query is evaluated against.
+type Event struct {
+	// Field is the subscription's root field name, e.g. "commentAdded".
+	Field string
+
+	// Args holds the literal argument values supplied for Field, e.g.
+	// {"postId": "abc"} for commentAdded(postId: "abc").
+	Args map[string]interface{}
+
+	// Payload holds the event's data, addressable in queries as
+	// payload.<key>.
+	Payload map[string]interface{}
+}
+
+// Query is a compiled filter expression that can be evaluated against an
+// Event. Compile a Query once (e.g. when a subscription is registered) and
+// reuse it for every published event.
+type Query interface {
+	Matches(event Event) bool
+}
+
+// Parse compiles source into a Query. source is a small boolean expression
+// language, e.g.:
+//
+//	field = 'commentAdded' AND args.postId = 'abc' AND payload.author != 'bot'
+//
+// Supported operators are "=" and "!=", combined with AND/OR (AND binds
+// tighter than OR, both left-associative) and grouped with parentheses.
+// Identifiers are `field`, `args.<name>` or `payload.<name>`; literals are
+// single-quoted strings, numbers, or `true`/`false`.
+func Parse(source string) (Query, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("query: unexpected token %q", p.peek().text)
+	}
+	return expr, nil
+}
+
+// Equals returns a Query that matches when identifier ("field",
+// "args.<name>" or "payload.<name>") equals value.
+func Equals(identifier string, value interface{}) Query {
+	return &compareExpr{identifier: identifier, value: value}
+}
+
+// NotEquals returns a Query that matches when identifier does not equal
+// value.
+func NotEquals(identifier string, value interface{}) Query {
+	return &compareExpr{identifier: identifier, value: value, negate: true}
+}
+
+// And returns a Query that matches when every given query matches. And()
+// with no arguments matches everything.
+func And(queries ...Query) Query {
+	return combine(queries, func(l, r Query) Query { return &andExpr{left: l, right: r} }, matchAllQuery{})
+}
+
+// Any returns a Query that matches when at least one given query matches.
+// Any() with no arguments matches nothing.
+func Any(queries ...Query) Query {
+	return combine(queries, func(l, r Query) Query { return &orExpr{left: l, right: r} }, matchNoneQuery{})
+}
+
+func combine(queries []Query, join func(l, r Query) Query, identity Query) Query {
+	if len(queries) == 0 {
+		return identity
+	}
+
+	result := queries[0]
+	for _, q := range queries[1:] {
+		result = join(result, q)
+	}
+	return result
+}
+
+type matchAllQuery struct{}
+
+func (matchAllQuery) Matches(Event) bool { return true }
+
+type matchNoneQuery struct{}
+
+func (matchNoneQuery) Matches(Event) bool { return false }
+
+type andExpr struct{ left, right Query }
+
+func (e *andExpr) Matches(event Event) bool {
+	return e.left.Matches(event) && e.right.Matches(event)
+}
+
+type orExpr struct{ left, right Query }
+
+func (e *orExpr) Matches(event Event) bool {
+	return e.left.Matches(event) || e.right.Matches(event)
+}
+
+type compareExpr struct {
+	identifier string
+	negate     bool
+	value      interface{}
+}
+
+func (e *compareExpr) Matches(event Event) bool {
+	actual, ok := resolveIdentifier(event, e.identifier)
+	if !ok {
+		return false
+	}
+
+	equal := valuesEqual(actual, e.value)
+	if e.negate {
+		return !equal
+	}
+	return equal
+}
+
+func resolveIdentifier(event Event, identifier string) (interface{}, bool) {
+	switch {
+	case identifier == "field":
+		return event.Field, true
+	case strings.HasPrefix(identifier, "args."):
+		value, ok := event.Args[strings.TrimPrefix(identifier, "args.")]
+		return value, ok
+	case strings.HasPrefix(identifier, "payload."):
+		value, ok := event.Payload[strings.TrimPrefix(identifier, "payload.")]
+		return value, ok
+	default:
+		return nil, false
+	}
+}
+
+// valuesEqual compares two values coercing numeric Go types (so
+// `args.count = 3` matches regardless of whether count is stored as an
+// int or a float64) but never a string, numeric-looking or not: treating
+// a string as a number would make e.g. `args.postId = '1'` match "001"
+// or "1e0", which is almost never what a caller filtering on an opaque
+// ID-shaped string wants. Anything that isn't a bool or a pair of
+// numeric types is compared as its default string representation.
+func valuesEqual(a, b interface{}) bool {
+	if af, aok := toFloat(a); aok {
+		if bf, bok := toFloat(b); bok {
+			return af == bf
+		}
+	}
+
+	// A string never equals a number, however it's spelled.
+	if _, aIsString := a.(string); aIsString {
+		if _, bok := toFloat(b); bok {
+			return false
+		}
+	}
+	if _, bIsString := b.(string); bIsString {
+		if _, aok := toFloat(a); aok {
+			return false
+		}
+	}
+
+	if ab, aok := a.(bool); aok {
+		if bb, bok := b.(bool); bok {
+			return ab == bb
+		}
+	}
+
+	return fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b)
+}
+
+// toFloat reports the numeric value of v if it's a genuine numeric Go
+// type. Strings are deliberately excluded: see valuesEqual.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
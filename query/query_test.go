@@ -0,0 +1,119 @@
+package query
+
+import "testing"
+
+func mustParse(t *testing.T, source string) Query {
+	t.Helper()
+	q, err := Parse(source)
+	if err != nil {
+		t.Fatalf("Parse(%q): unexpected error: %v", source, err)
+	}
+	return q
+}
+
+func TestParseOperatorPrecedence(t *testing.T) {
+	// AND binds tighter than OR, so this reads as
+	// (field = 'a') OR (field = 'b' AND args.x = '1'), matching plain
+	// field = 'a' events even though args.x never equals '1' for them.
+	q := mustParse(t, "field = 'a' OR field = 'b' AND args.x = '1'")
+
+	if !q.Matches(Event{Field: "a"}) {
+		t.Error("expected field = 'a' to match without args.x")
+	}
+	if q.Matches(Event{Field: "b"}) {
+		t.Error("did not expect field = 'b' to match without args.x = '1'")
+	}
+	if !q.Matches(Event{Field: "b", Args: map[string]interface{}{"x": "1"}}) {
+		t.Error("expected field = 'b' AND args.x = '1' to match")
+	}
+}
+
+func TestParseParenthesesOverridePrecedence(t *testing.T) {
+	// Without parentheses this would be field = 'a' OR (field = 'b' AND
+	// args.x = '1'); with them it's (field = 'a' OR field = 'b') AND
+	// args.x = '1', so field = 'a' alone (no args.x) must stop matching.
+	q := mustParse(t, "(field = 'a' OR field = 'b') AND args.x = '1'")
+
+	if q.Matches(Event{Field: "a"}) {
+		t.Error("did not expect field = 'a' to match without args.x = '1'")
+	}
+	if !q.Matches(Event{Field: "a", Args: map[string]interface{}{"x": "1"}}) {
+		t.Error("expected field = 'a' AND args.x = '1' to match")
+	}
+	if !q.Matches(Event{Field: "b", Args: map[string]interface{}{"x": "1"}}) {
+		t.Error("expected field = 'b' AND args.x = '1' to match")
+	}
+}
+
+func TestTypeCoercion(t *testing.T) {
+	q := mustParse(t, "args.count = 3")
+
+	cases := []struct {
+		name  string
+		value interface{}
+		want  bool
+	}{
+		{"int", 3, true},
+		{"float64", float64(3), true},
+		{"mismatched number", 4, false},
+		// A string is never coerced to a number, numeric-looking or not:
+		// an opaque ID-shaped string like "3" must not match the number
+		// literal 3, or "001"/"1e0" would wrongly match '1'.
+		{"numeric string", "3", false},
+		{"non-numeric string", "three", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := q.Matches(Event{Args: map[string]interface{}{"count": c.value}})
+			if got != c.want {
+				t.Errorf("Matches with count=%v (%s) = %v, want %v", c.value, c.name, got, c.want)
+			}
+		})
+	}
+}
+
+func TestStringsNeverCoerceNumerically(t *testing.T) {
+	// args.postId = '1' must only match the literal string "1", not
+	// other strings that happen to parse to the same number.
+	q := mustParse(t, "args.postId = '1'")
+
+	if !q.Matches(Event{Args: map[string]interface{}{"postId": "1"}}) {
+		t.Error("expected postId \"1\" to match")
+	}
+	if q.Matches(Event{Args: map[string]interface{}{"postId": "001"}}) {
+		t.Error("did not expect postId \"001\" to match '1'")
+	}
+	if q.Matches(Event{Args: map[string]interface{}{"postId": "1e0"}}) {
+		t.Error("did not expect postId \"1e0\" to match '1'")
+	}
+}
+
+func TestUnknownIdentifierRejectedAtParse(t *testing.T) {
+	_, err := Parse("bogus = 'x'")
+	if err == nil {
+		t.Fatal("expected an error parsing an unknown identifier, got nil")
+	}
+}
+
+func TestUnknownIdentifierAtRuntimeDoesNotMatch(t *testing.T) {
+	// payload.<key> and args.<key> are only resolvable at evaluation
+	// time: a key absent from the event's map must not match rather
+	// than panic or silently compare against a zero value.
+	q := mustParse(t, "payload.missing = 'x'")
+
+	if q.Matches(Event{Payload: map[string]interface{}{"other": "x"}}) {
+		t.Error("expected no match when payload.missing isn't present")
+	}
+}
+
+func TestNotEquals(t *testing.T) {
+	q := mustParse(t, "field != 'a'")
+
+	if q.Matches(Event{Field: "a"}) {
+		t.Error("did not expect field != 'a' to match field 'a'")
+	}
+	if !q.Matches(Event{Field: "b"}) {
+		t.Error("expected field != 'a' to match field 'b'")
+	}
+}
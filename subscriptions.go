@@ -1,9 +1,11 @@
 package graphqlws
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"sync"
 
+	"github.com/eirwin/graphqlws/query"
 	"github.com/graphql-go/graphql"
 	"github.com/graphql-go/graphql/gqlerrors"
 	"github.com/graphql-go/graphql/language/ast"
@@ -38,12 +40,76 @@ type Subscription struct {
 	OperationName string
 	Document      *ast.Document
 	Fields        []string
+	FieldArgs     map[string]map[string]interface{}
 	Connection    Connection
 	SendData      SubscriptionSendDataFunc
+
+	// DeliveryPolicy controls what happens when this subscription's
+	// outbound buffer fills up faster than its client can drain it.
+	// Defaults to PolicyBlock.
+	DeliveryPolicy SubscriptionDeliveryPolicy
+
+	// BufferSize sets the capacity of the subscription's outbound
+	// delivery buffer. Defaults to DefaultDeliveryBufferSize.
+	BufferSize int
+
+	// OnCancelled, if set, is invoked when the subscription is
+	// force-disconnected by its DeliveryPolicy.
+	OnCancelled func(error)
+
+	// Name identifies a server-side persisted subscription so a client
+	// can resume it across reconnects by referencing Name instead of
+	// sending an inline query. Only meaningful with a subscription
+	// manager created via NewPersistentSubscriptionManager.
+	Name string
+
+	// Checkpoint is the last delivery checkpoint acknowledged for this
+	// subscription, as reported via SubscriptionManager.Ack.
+	Checkpoint string
+
+	// CheckpointFunc, if set, computes a checkpoint string for each
+	// payload as it's delivered, so a PersistentSubscriptionStore can
+	// record replay position without the client having to Ack every
+	// single message.
+	CheckpointFunc CheckpointFunc
+
+	// Filter compiles which published events this subscription accepts.
+	// If left unset, it's lazily initialized by Matches to the query
+	// NewQueryFromSubscription derives from the subscription's selection
+	// set (field name plus literal argument values).
+	Filter query.Query
+
+	// onCheckpoint is invoked with the value CheckpointFunc returns and
+	// the payload it was computed from, for each delivered payload. Only
+	// the persistent manager sets it, for subscriptions with a Name: it
+	// updates Checkpoint and writes the payload and checkpoint to the
+	// backing store. Elsewhere it stays nil and CheckpointFunc is unused.
+	onCheckpoint func(string, *DataMessagePayload)
+
+	// cancel tears down the running graphql.Subscribe execution for this
+	// subscription. It is set by startSubscription and invoked whenever
+	// the subscription is removed.
+	cancel context.CancelFunc
+
+	// usesNativeSubscribe records whether this subscription is driven by
+	// graphql-go's Subscribe pipeline (startSubscription), so Publish can
+	// skip it and avoid delivering every event twice.
+	usesNativeSubscribe bool
+
+	// Delivery plumbing set up by initDelivery; see delivery.go.
+	out             chan *DataMessagePayload
+	cancelled       chan struct{}
+	cancelledOnce   sync.Once
+	forceDisconnect func(error)
+	mu              sync.Mutex
+	err             error
 }
 
 // MatchesField returns true if the subscription is for data that
 // belongs to the given field.
+//
+// Deprecated: MatchesField only compares field names. Use Matches, which
+// also filters on arguments and payload contents.
 func (s *Subscription) MatchesField(field string) bool {
 	if s.Document == nil || len(s.Fields) == 0 {
 		return false
@@ -59,6 +125,44 @@ func (s *Subscription) MatchesField(field string) bool {
 	return false
 }
 
+// Matches reports whether the subscription accepts event. If the
+// subscription doesn't have an explicit Filter, one is derived from its
+// selection set via NewQueryFromSubscription and cached on first use.
+func (s *Subscription) Matches(event query.Event) bool {
+	if s.Filter == nil {
+		s.Filter = NewQueryFromSubscription(s)
+	}
+	return s.Filter.Matches(event)
+}
+
+// NewQueryFromSubscription derives a default query.Query for subscription
+// from its parsed Document: it matches an event whose field is one of the
+// subscription's root selections and whose args equal that selection's
+// literal argument values (resolving GraphQL variables against the
+// subscription's Variables).
+func NewQueryFromSubscription(subscription *Subscription) query.Query {
+	if len(subscription.Fields) == 0 {
+		return query.Any()
+	}
+
+	queries := make([]query.Query, 0, len(subscription.Fields))
+	for _, field := range subscription.Fields {
+		queries = append(queries, fieldQuery(field, subscription.FieldArgs[field]))
+	}
+	return query.Any(queries...)
+}
+
+// fieldQuery builds the query that matches field when the event's args
+// hold the given literal argument values; extra args don't prevent a match.
+func fieldQuery(field string, args map[string]interface{}) query.Query {
+	filters := make([]query.Query, 0, len(args)+1)
+	filters = append(filters, query.Equals("field", field))
+	for name, value := range args {
+		filters = append(filters, query.Equals(fmt.Sprintf("args.%s", name), value))
+	}
+	return query.And(filters...)
+}
+
 // ConnectionSubscriptions defines a map of all subscriptions of
 // a connection by their IDs.
 // type ConnectionSubscriptions map[string]*Subscription
@@ -77,11 +181,36 @@ type SubscriptionManager interface {
 	// AddSubscription adds a new subscription to the manager.
 	AddSubscription(Connection, *Subscription) []error
 
-	// RemoveSubscription removes a subscription from the manager.
-	RemoveSubscription(Connection, *Subscription)
+	// RemoveSubscription removes a subscription from the manager. It
+	// returns ErrConnectionUnknown if the subscription was never
+	// registered on a connection the manager knows, or
+	// ErrSubscriptionNotFound if the subscription itself isn't (or is no
+	// longer) registered, including after its removal dropped the
+	// connection's last subscription; either way manager state is
+	// left consistent, so it's safe to call more than once for the same
+	// subscription.
+	//
+	// Migration note: RemoveSubscription used to return nothing and log
+	// on failure. Callers that ignored the old void return can keep
+	// doing so; callers that want to detect a no-op removal should check
+	// the returned error with errors.Is.
+	RemoveSubscription(Connection, *Subscription) error
 
 	// RemoveSubscriptions removes all subscriptions of a client connection.
 	RemoveSubscriptions(Connection)
+
+	// Publish delivers payload to every subscription registered for field
+	// whose argument filter matches args, executing each matching
+	// subscription's query and fanning the result out via its SendData
+	// callback. Subscriptions driven by graphql-go's Subscribe pipeline
+	// are skipped.
+	Publish(field string, args map[string]interface{}, payload interface{})
+
+	// Ack records checkpoint as the last delivery acknowledged by the
+	// client for the subscription subID on conn. For named subscriptions
+	// of a manager created with NewPersistentSubscriptionManager, the
+	// checkpoint is also written through to the backing store.
+	Ack(conn Connection, subID string, checkpoint string) error
 }
 
 /**
@@ -92,6 +221,7 @@ type subscriptionManager struct {
 	subscriptions *sync.Map
 	schema        *graphql.Schema
 	logger        *log.Entry
+	index         *subIndex
 }
 
 func NewSubscriptionManagerWithLogger(schema *graphql.Schema, logger *log.Entry) SubscriptionManager {
@@ -104,7 +234,12 @@ func NewSubscriptionManager(schema *graphql.Schema) SubscriptionManager {
 }
 
 func newSubscriptionManager(schema *graphql.Schema, logger *log.Entry) SubscriptionManager {
-	return &subscriptionManager{schema: schema, logger: logger, subscriptions: &sync.Map{}}
+	return &subscriptionManager{
+		schema:        schema,
+		logger:        logger,
+		subscriptions: &sync.Map{},
+		index:         newSubIndex(),
+	}
 }
 
 func (m *subscriptionManager) Subscriptions() *sync.Map {
@@ -114,6 +249,25 @@ func (m *subscriptionManager) Subscriptions() *sync.Map {
 func (m *subscriptionManager) AddSubscription(
 	conn Connection,
 	subscription *Subscription,
+) []error {
+	if errs := m.registerSubscription(conn, subscription); len(errs) > 0 {
+		return errs
+	}
+	m.index.add(subscription)
+	return nil
+}
+
+// registerSubscription validates, parses and stores subscription and
+// starts its delivery worker and (if applicable) native Subscribe
+// pipeline, but deliberately stops short of m.index.add: it's not yet
+// visible to Publish when this returns. AddSubscription adds it to the
+// index itself right after; persistentSubscriptionManager.AddSubscription
+// instead enqueues replayed backlog first and adds it to the index once
+// that's done, so Publish can never deliver a live event to a resuming
+// subscription ahead of the history it's supposed to catch up on.
+func (m *subscriptionManager) registerSubscription(
+	conn Connection,
+	subscription *Subscription,
 ) []error {
 	m.logger.WithFields(log.Fields{
 		"conn":         conn.ID(),
@@ -149,6 +303,10 @@ func (m *subscriptionManager) AddSubscription(
 	// Extract query names from the document (typically, there should only be one)
 	subscription.Fields = subscriptionFieldNamesFromDocument(document)
 
+	// Extract the literal argument filters for each field, so Publish can
+	// route events to the right subscriptions without re-parsing the query
+	subscription.FieldArgs = subscriptionFieldArgsFromDocument(document, subscription.Variables)
+
 	// Allocate the connection's map of subscription IDs to
 	// subscriptions on demand
 	connSubsInterface, _ := m.subscriptions.LoadOrStore(conn, &sync.Map{})
@@ -160,18 +318,79 @@ func (m *subscriptionManager) AddSubscription(
 			"conn":         conn.ID(),
 			"subscription": subscription.ID,
 		}).Warn("Cannot register subscription twice")
-		return []error{errors.New("Cannot register subscription twice")}
+		return []error{fmt.Errorf("%w: %s", ErrAlreadySubscribed, subscription.ID)}
 	}
 
 	connSubs.Store(subscription.ID, subscription)
 
+	subscription.initDelivery(func(sub *Subscription, err error) {
+		if removeErr := m.RemoveSubscription(conn, sub); removeErr != nil {
+			m.logger.WithField("err", removeErr).Warn("Failed to remove force-disconnected subscription")
+		}
+	})
+	go subscription.runDelivery()
+
+	// Only drive this subscription off graphql-go's Subscribe pipeline if
+	// its field actually declares a Subscribe resolver. Otherwise delivery
+	// is Publish-driven (see Publish below), and starting the pipeline
+	// anyway would immediately fail every subscription with a spurious
+	// "the subscription function is not defined" error.
+	subscription.usesNativeSubscribe = anyFieldHasSubscribeResolver(m.schema, subscription.Fields)
+	if subscription.usesNativeSubscribe {
+		m.startSubscription(subscription)
+	}
+
 	return nil
 }
 
+// anyFieldHasSubscribeResolver reports whether the schema's subscription
+// type declares a Subscribe resolver for any of the given root fields.
+func anyFieldHasSubscribeResolver(schema *graphql.Schema, fields []string) bool {
+	subscriptionType := schema.SubscriptionType()
+	if subscriptionType == nil {
+		return false
+	}
+
+	defs := subscriptionType.Fields()
+	for _, field := range fields {
+		if def, ok := defs[field]; ok && def.Subscribe != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// startSubscription runs the subscription's query against the manager's
+// schema using graphql-go's Subscribe pipeline and forwards every result
+// emitted on the returned channel to the subscription's SendData callback.
+// The execution keeps running, driven by the schema's Subscribe field
+// resolvers, until the subscription's context is cancelled.
+func (m *subscriptionManager) startSubscription(subscription *Subscription) {
+	ctx, cancel := context.WithCancel(context.Background())
+	subscription.cancel = cancel
+
+	results := graphql.Subscribe(graphql.Params{
+		Schema:         *m.schema,
+		RequestString:  subscription.Query,
+		VariableValues: subscription.Variables,
+		OperationName:  subscription.OperationName,
+		Context:        ctx,
+	})
+
+	go func() {
+		for result := range results {
+			subscription.deliver(&DataMessagePayload{
+				Data:   result.Data,
+				Errors: ErrorsFromGraphQLErrors(result.Errors),
+			})
+		}
+	}()
+}
+
 func (m *subscriptionManager) RemoveSubscription(
 	conn Connection,
 	subscription *Subscription,
-) {
+) error {
 	m.logger.WithFields(log.Fields{
 		"conn":         conn.ID(),
 		"subscription": subscription.ID,
@@ -180,10 +399,32 @@ func (m *subscriptionManager) RemoveSubscription(
 	// Remove the subscription from its connections' subscription map
 	subsInterface, ok := m.subscriptions.Load(conn)
 	if !ok {
-		m.logger.Errorf("Couldn't remove subscription, connection doesn't exist")
-		return
+		// A subscription that went through initDelivery on this connection
+		// was registered here; its connection entry is gone only because
+		// removing its last subscription dropped it.
+		if subscription.cancelled != nil && subscription.Connection != nil &&
+			subscription.Connection.ID() == conn.ID() {
+			return ErrSubscriptionNotFound
+		}
+		m.logger.Warn("Couldn't remove subscription, connection doesn't exist")
+		return ErrConnectionUnknown
 	}
 	subs := subsInterface.(*sync.Map)
+
+	stored, ok := subs.Load(subscription.ID)
+	if !ok {
+		// Already removed (or never registered): leave state as-is and
+		// report it so callers can tell a no-op removal from a real one.
+		return ErrSubscriptionNotFound
+	}
+
+	if sub, ok := stored.(*Subscription); ok {
+		if sub.cancel != nil {
+			sub.cancel()
+		}
+		sub.stopDelivery()
+		m.index.remove(sub)
+	}
 	subs.Delete(subscription.ID)
 
 	hasElems := false
@@ -196,6 +437,8 @@ func (m *subscriptionManager) RemoveSubscription(
 	if !hasElems {
 		m.subscriptions.Delete(conn)
 	}
+
+	return nil
 }
 
 func (m *subscriptionManager) RemoveSubscriptions(conn Connection) {
@@ -210,7 +453,9 @@ func (m *subscriptionManager) RemoveSubscriptions(conn Connection) {
 		// Remove subscriptions one by one
 
 		connSubs.Range(func(key, value interface{}) bool {
-			m.RemoveSubscription(conn, value.(*Subscription))
+			if err := m.RemoveSubscription(conn, value.(*Subscription)); err != nil {
+				m.logger.WithField("err", err).Warn("Failed to remove subscription")
+			}
 			return true
 		})
 
@@ -219,23 +464,89 @@ func (m *subscriptionManager) RemoveSubscriptions(conn Connection) {
 	}
 }
 
+// Publish looks up the subscriptions registered for field via the
+// manager's index, evaluates their argument filters against args, and
+// executes each match's query once, sending the result through the
+// matching subscription's SendData callback. Subscriptions driven by
+// graphql-go's Subscribe pipeline are skipped.
+func (m *subscriptionManager) Publish(field string, args map[string]interface{}, payload interface{}) {
+	matches := m.index.match(field, args)
+	if len(matches) == 0 {
+		return
+	}
+
+	m.logger.WithFields(log.Fields{
+		"field":   field,
+		"matches": len(matches),
+	}).Debug("Publish")
+
+	event := query.Event{Field: field, Args: args}
+	if payloadMap, ok := payload.(map[string]interface{}); ok {
+		event.Payload = payloadMap
+	}
+
+	for _, subscription := range matches {
+		if subscription.usesNativeSubscribe {
+			// Already being driven by graphql-go's Subscribe pipeline;
+			// executing it again here would deliver every event twice.
+			continue
+		}
+
+		if !subscription.Matches(event) {
+			continue
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         *m.schema,
+			RequestString:  subscription.Query,
+			VariableValues: subscription.Variables,
+			OperationName:  subscription.OperationName,
+			RootObject:     map[string]interface{}{field: payload},
+		})
+
+		subscription.deliver(&DataMessagePayload{
+			Data:   result.Data,
+			Errors: ErrorsFromGraphQLErrors(result.Errors),
+		})
+	}
+}
+
+// Ack records checkpoint as the last delivery acknowledged for subID on
+// conn. The default manager only tracks it on the subscription itself;
+// NewPersistentSubscriptionManager overrides this to also persist it.
+func (m *subscriptionManager) Ack(conn Connection, subID string, checkpoint string) error {
+	subsInterface, ok := m.subscriptions.Load(conn)
+	if !ok {
+		return ErrConnectionUnknown
+	}
+	subs := subsInterface.(*sync.Map)
+
+	stored, ok := subs.Load(subID)
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+
+	stored.(*Subscription).Checkpoint = checkpoint
+	return nil
+}
+
 func validateSubscription(s *Subscription) []error {
 	errs := []error{}
 
 	if s.ID == "" {
-		errs = append(errs, errors.New("Subscription ID is empty"))
+		errs = append(errs, fmt.Errorf("%w: subscription ID is empty", ErrInvalidSubscription))
 	}
 
 	if s.Connection == nil {
-		errs = append(errs, errors.New("Subscription is not associated with a connection"))
+		errs = append(errs, fmt.Errorf("%w: subscription is not associated with a connection", ErrInvalidSubscription))
 	}
 
 	if s.Query == "" {
-		errs = append(errs, errors.New("Subscription query is empty"))
+		errs = append(errs, fmt.Errorf("%w: subscription query is empty", ErrInvalidSubscription))
 	}
 
 	if s.SendData == nil {
-		errs = append(errs, errors.New("Subscription has no SendData function set"))
+		errs = append(errs, fmt.Errorf("%w: subscription has no SendData function set", ErrInvalidSubscription))
 	}
 
 	return errs
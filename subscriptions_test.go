@@ -0,0 +1,117 @@
+package graphqlws
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+)
+
+// fakeConnection is the minimal Connection implementation these tests
+// need: everything this package does with a Connection is keyed off ID().
+type fakeConnection struct {
+	id string
+}
+
+func (c *fakeConnection) ID() string { return c.id }
+
+// registerForTest wires sub into m's internal state the way
+// registerSubscription would, without needing a real GraphQL schema to
+// parse a query against.
+func registerForTest(m *subscriptionManager, conn Connection, sub *Subscription) {
+	connSubsInterface, _ := m.subscriptions.LoadOrStore(conn, &sync.Map{})
+	connSubsInterface.(*sync.Map).Store(sub.ID, sub)
+	m.index.add(sub)
+	sub.initDelivery(nil)
+}
+
+func TestRemoveSubscriptionIdempotent(t *testing.T) {
+	m := newSubscriptionManager(nil, NewLogger("test")).(*subscriptionManager)
+	conn := &fakeConnection{id: "conn-1"}
+	sub := &Subscription{ID: "sub-1", Connection: conn, Fields: []string{"messageAdded"}}
+	registerForTest(m, conn, sub)
+
+	if err := m.RemoveSubscription(conn, sub); err != nil {
+		t.Fatalf("first RemoveSubscription: unexpected error: %v", err)
+	}
+	if err := m.RemoveSubscription(conn, sub); !errors.Is(err, ErrSubscriptionNotFound) {
+		t.Fatalf("second RemoveSubscription: got %v, want ErrSubscriptionNotFound", err)
+	}
+
+	// The connection's subscription map should have been cleaned up along
+	// with its last subscription.
+	if _, ok := m.subscriptions.Load(conn); ok {
+		t.Error("expected the connection to be removed once its last subscription is removed")
+	}
+}
+
+func TestRemoveSubscriptionUnknownConnection(t *testing.T) {
+	m := newSubscriptionManager(nil, NewLogger("test")).(*subscriptionManager)
+	conn := &fakeConnection{id: "conn-1"}
+	sub := &Subscription{ID: "sub-1", Connection: conn}
+
+	if err := m.RemoveSubscription(conn, sub); !errors.Is(err, ErrConnectionUnknown) {
+		t.Fatalf("got %v, want ErrConnectionUnknown", err)
+	}
+}
+
+func TestRemoveSubscriptionsOnUnknownConnectionIsNoop(t *testing.T) {
+	m := newSubscriptionManager(nil, NewLogger("test")).(*subscriptionManager)
+	conn := &fakeConnection{id: "conn-1"}
+
+	// Must not panic when the connection was never registered.
+	m.RemoveSubscriptions(conn)
+}
+
+func TestAnyFieldHasSubscribeResolver(t *testing.T) {
+	subscriptionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"messageAdded": &graphql.Field{
+				Type:      graphql.String,
+				Subscribe: func(p graphql.ResolveParams) (interface{}, error) { return nil, nil },
+			},
+			"commentAdded": &graphql.Field{
+				Type: graphql.String,
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Query",
+			Fields: graphql.Fields{"ping": &graphql.Field{Type: graphql.String}},
+		}),
+		Subscription: subscriptionType,
+	})
+	if err != nil {
+		t.Fatalf("NewSchema: %v", err)
+	}
+
+	if !anyFieldHasSubscribeResolver(&schema, []string{"messageAdded"}) {
+		t.Error("expected messageAdded (has a Subscribe resolver) to report true")
+	}
+	if anyFieldHasSubscribeResolver(&schema, []string{"commentAdded"}) {
+		t.Error("expected commentAdded (no Subscribe resolver) to report false")
+	}
+	if anyFieldHasSubscribeResolver(&schema, []string{"doesNotExist"}) {
+		t.Error("expected an unknown field to report false")
+	}
+}
+
+func TestAnyFieldHasSubscribeResolverNoSubscriptionType(t *testing.T) {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Query",
+			Fields: graphql.Fields{"ping": &graphql.Field{Type: graphql.String}},
+		}),
+	})
+	if err != nil {
+		t.Fatalf("NewSchema: %v", err)
+	}
+
+	if anyFieldHasSubscribeResolver(&schema, []string{"anything"}) {
+		t.Error("expected false when the schema declares no subscription type")
+	}
+}